@@ -0,0 +1,172 @@
+package direct
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// VerifierFactory builds a Verifier from the raw public key bytes substrate
+// returns for a twin account.
+type VerifierFactory func(pub []byte) (Verifier, error)
+
+// SignerFactory builds a Signer from raw private key material.
+type SignerFactory func(priv []byte) (Signer, error)
+
+// Signer is the signing counterpart of Verifier: it produces a signature
+// over msg using whatever key material it was constructed with.
+type Signer interface {
+	Sign(msg []byte) ([]byte, error)
+}
+
+type scheme struct {
+	prefix      byte
+	newVerifier VerifierFactory
+	newSigner   SignerFactory
+}
+
+// SchemeRegistry maps a signature scheme name, and the single byte used to
+// tag it on the wire, to the Verifier/Signer implementations that handle it.
+// It turns the signature layer into an extension point: applications can
+// register additional schemes (ECDSA, BLS, post-quantum experiments, ...)
+// without forking this package.
+type SchemeRegistry struct {
+	mu       sync.RWMutex
+	byName   map[string]scheme
+	byPrefix map[byte]string
+}
+
+// NewSchemeRegistry creates an empty registry. Most callers want
+// DefaultRegistry instead, which already knows about the schemes this
+// package ships with.
+func NewSchemeRegistry() *SchemeRegistry {
+	return &SchemeRegistry{
+		byName:   make(map[string]scheme),
+		byPrefix: make(map[byte]string),
+	}
+}
+
+// Register adds a verifier factory for name, tagged on the wire with prefix.
+// It fails if name or prefix is already registered.
+func (r *SchemeRegistry) Register(name string, prefix byte, newVerifier VerifierFactory) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byName[name]; ok {
+		return fmt.Errorf("signature scheme %s is already registered", name)
+	}
+	if existing, ok := r.byPrefix[prefix]; ok {
+		return fmt.Errorf("signature prefix %x is already registered to %s", []byte{prefix}, existing)
+	}
+
+	r.byName[name] = scheme{prefix: prefix, newVerifier: newVerifier}
+	r.byPrefix[prefix] = name
+	return nil
+}
+
+// RegisterSigner attaches a signer factory to a scheme that was already
+// added with Register.
+func (r *SchemeRegistry) RegisterSigner(name string, newSigner SignerFactory) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.byName[name]
+	if !ok {
+		return fmt.Errorf("unrecognized signature scheme %s", name)
+	}
+	s.newSigner = newSigner
+	r.byName[name] = s
+	return nil
+}
+
+// Verifier builds a Verifier for the named scheme from pub.
+func (r *SchemeRegistry) Verifier(name string, pub []byte) (Verifier, error) {
+	r.mu.RLock()
+	s, ok := r.byName[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unrecognized key type %s", name)
+	}
+	return s.newVerifier(pub)
+}
+
+// Signer builds a Signer for the named scheme from priv. It fails if the
+// scheme has no signer factory registered.
+func (r *SchemeRegistry) Signer(name string, priv []byte) (Signer, error) {
+	r.mu.RLock()
+	s, ok := r.byName[name]
+	r.mu.RUnlock()
+	if !ok || s.newSigner == nil {
+		return nil, fmt.Errorf("no signer registered for signature scheme %s", name)
+	}
+	return s.newSigner(priv)
+}
+
+// Prefix returns the wire-format byte registered for name.
+func (r *SchemeRegistry) Prefix(name string) (byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.byName[name]
+	if !ok {
+		return 0, fmt.Errorf("unrecognized signature type %s", name)
+	}
+	return s.prefix, nil
+}
+
+// Name returns the scheme name registered for prefix.
+func (r *SchemeRegistry) Name(prefix byte) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	name, ok := r.byPrefix[prefix]
+	if !ok {
+		return "", fmt.Errorf("unrecognized signature prefix %x", []byte{prefix})
+	}
+	return name, nil
+}
+
+// WireScheme looks up the scheme name for a signature's leading byte. Doing
+// this before spending an RPC on the twin lookup rejects a flood of
+// garbage/unregistered scheme bytes cheaply instead of hammering the node.
+func (r *SchemeRegistry) WireScheme(sig []byte) (string, error) {
+	if len(sig) == 0 {
+		return "", errors.New("signature is empty")
+	}
+	name, err := r.Name(sig[0])
+	if err != nil {
+		return "", errors.Wrap(err, "could not determine signature scheme from envelope")
+	}
+	return name, nil
+}
+
+// DefaultRegistry is seeded with the signature schemes this package ships
+// with. Downstream code can call DefaultRegistry.Register to add more
+// without forking this file, or build a private SchemeRegistry to keep a
+// custom scheme set out of the global default.
+var DefaultRegistry = NewSchemeRegistry()
+
+func init() {
+	registerDefault(SignatureTypeEd25519, 'e',
+		func(pub []byte) (Verifier, error) { return Ed25519VerifyingKey(pub), nil },
+		func(priv []byte) (Signer, error) { return Ed25519SigningKey(priv), nil },
+	)
+	registerDefault(SignatureTypeSr25519, 's',
+		func(pub []byte) (Verifier, error) { return Sr25519VerifyingKey(pub), nil },
+		func(priv []byte) (Signer, error) { return Sr25519SigningKey(priv), nil },
+	)
+	registerDefault(SignatureTypeEcdsa, 'c',
+		func(pub []byte) (Verifier, error) { return EcdsaVerifyingKey(pub), nil },
+		nil,
+	)
+}
+
+func registerDefault(name string, prefix byte, newVerifier VerifierFactory, newSigner SignerFactory) {
+	if err := DefaultRegistry.Register(name, prefix, newVerifier); err != nil {
+		panic(err)
+	}
+	if newSigner != nil {
+		if err := DefaultRegistry.RegisterSigner(name, newSigner); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"fmt"
 
 	sr25519 "github.com/ChainSafe/go-schnorrkel"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/rmb-sdk-go/direct/types"
 	"github.com/threefoldtech/substrate-client"
+	"golang.org/x/crypto/blake2b"
 
 	"github.com/gtank/merlin"
 	"github.com/rs/zerolog/log"
@@ -17,6 +20,7 @@ import (
 const (
 	SignatureTypeEd25519 = "ed25519"
 	SignatureTypeSr25519 = "sr25519"
+	SignatureTypeEcdsa   = "ecdsa"
 )
 
 type Verifier interface {
@@ -70,57 +74,116 @@ func (k Sr25519VerifyingKey) Verify(msg []byte, sig []byte) bool {
 	return k.verify(*pk, msg, sig)
 }
 
-func constructVerifier(publicKey []byte, key_type string) (Verifier, error) {
-	if key_type == SignatureTypeEd25519 {
-		return Ed25519VerifyingKey(publicKey), nil
-	} else if key_type == SignatureTypeSr25519 {
-		return Sr25519VerifyingKey(publicKey), nil
-	} else {
-		return nil, fmt.Errorf("unrecognized key type %s", key_type)
+// EcdsaVerifyingKey holds a 33-byte compressed secp256k1 public key, the
+// format substrate hands back for ECDSA twin accounts.
+type EcdsaVerifyingKey []byte
+
+func (k EcdsaVerifyingKey) Verify(msg []byte, sig []byte) bool {
+	if len(sig) < 64 {
+		return false
+	}
+	pub, err := btcec.ParsePubKey([]byte(k))
+	if err != nil {
+		return false
 	}
-}
 
-func sigTypeToChar(sigType string) (byte, error) {
-	if sigType == SignatureTypeEd25519 {
-		return byte('e'), nil
-	} else if sigType == SignatureTypeSr25519 {
-		return byte('s'), nil
-	} else {
-		return 0, fmt.Errorf("unrecognized signature type %s", sigType)
+	var r, s btcec.ModNScalar
+	if overflow := r.SetByteSlice(sig[:32]); overflow {
+		return false
 	}
+	if overflow := s.SetByteSlice(sig[32:64]); overflow {
+		return false
+	}
+
+	// substrate signs the blake2b-256 hash of the message for ECDSA accounts,
+	// not the raw message.
+	hash := blake2b.Sum256(msg)
+	return ecdsa.NewSignature(&r, &s).Verify(hash[:], pub)
 }
 
-func charToSigType(prefix byte) (string, error) {
-	if prefix == byte('e') {
-		return SignatureTypeEd25519, nil
-	} else if prefix == byte('s') {
-		return SignatureTypeSr25519, nil
-	} else {
-		return "", fmt.Errorf("unrecognized signature prefix %x", []byte{prefix})
-	}
+// Ed25519SigningKey holds a 64-byte ed25519 private key.
+type Ed25519SigningKey []byte
+
+func (k Ed25519SigningKey) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(k), msg), nil
 }
 
-func VerifySignature(sub *substrate.Substrate, env *types.Envelope) error {
+// Sr25519SigningKey holds a 32-byte sr25519 mini secret key, the format used
+// by substrate key pairs.
+type Sr25519SigningKey []byte
+
+func (k Sr25519SigningKey) Sign(msg []byte) ([]byte, error) {
+	var raw [32]byte
+	copy(raw[:], k)
 
-	twin, err := sub.GetTwin(env.Source.Twin)
+	mini, err := sr25519.NewMiniSecretKeyFromRaw(raw)
 	if err != nil {
-		return errors.Wrapf(err, "could not get twin from twin id, twinID: %d", env.Source.Twin)
+		return nil, err
+	}
+
+	sig, err := mini.ExpandEd25519().Sign(signingContext(msg))
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := sig.Encode()
+	return encoded[:], nil
+}
+
+// accountSignatureType derives the signature scheme and verification key for
+// twin's account. twin.Pk set is authoritative for ecdsa (AccountId32 can't
+// otherwise distinguish ed25519/sr25519/ecdsa on its own); only once that's
+// ruled out is the envelope's wireScheme trusted to pick between ed25519 and
+// sr25519, since both verify against the same raw account bytes anyway.
+func accountSignatureType(twin *substrate.Twin, wireScheme string) (signatureType string, pk []byte, err error) {
+	if hasPk, rawPk := twin.Pk.Unwrap(); hasPk && len(rawPk) > 0 {
+		return SignatureTypeEcdsa, []byte(rawPk), nil
+	}
+
+	if wireScheme == SignatureTypeEcdsa {
+		return "", nil, fmt.Errorf("twin has no registered ecdsa key, but envelope claims scheme %q", wireScheme)
+	}
+	return wireScheme, twin.Account.PublicKey(), nil
+}
+
+// VerifySignature checks that env carries a valid signature for its source
+// twin, looked up through resolver (or sub directly if resolver is nil) and
+// registry (or DefaultRegistry if nil).
+func VerifySignature(sub *substrate.Substrate, env *types.Envelope, registry *SchemeRegistry, resolver TwinResolver) error {
+	reg := registry
+	if reg == nil {
+		reg = DefaultRegistry
+	}
+	if resolver == nil {
+		resolver = substrateTwinResolver{sub: sub}
 	}
-	pk := twin.Account.PublicKey()
 
 	sig := env.GetSignature()
 	if sig == nil {
-		return errors.Wrap(err, "could not get signature from envelope")
+		return errors.New("could not get signature from envelope")
 	}
 	decoded, err := hex.DecodeString(string(sig))
 	if err != nil {
 		return errors.Wrap(err, "could not decode signature")
 	}
-	signatureType, err := charToSigType(decoded[0])
+	if len(decoded) == 0 {
+		return errors.New("signature is empty")
+	}
+	wireScheme, err := reg.WireScheme(decoded)
 	if err != nil {
-		return errors.Wrap(err, "got bad signature type should be either Ed25519 or Sr25519")
+		return err
 	}
-	verifier, err := constructVerifier(pk, signatureType)
+
+	twin, err := resolver.GetTwin(env.Source.Twin)
+	if err != nil {
+		return errors.Wrapf(err, "could not get twin from twin id, twinID: %d", env.Source.Twin)
+	}
+
+	signatureType, pk, err := accountSignatureType(twin, wireScheme)
+	if err != nil {
+		return errors.Wrapf(err, "could not determine signature scheme for twin %d", env.Source.Twin)
+	}
+	verifier, err := reg.Verifier(signatureType, pk)
 	if err != nil {
 		return err
 	}
@@ -132,4 +195,4 @@ func VerifySignature(sub *substrate.Substrate, env *types.Envelope) error {
 		return fmt.Errorf("could not verify signature")
 	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,81 @@
+package direct
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"golang.org/x/crypto/blake2b"
+)
+
+// TestEcdsaVerifyingKey_Verify_KnownVector pins a fixed secp256k1 keypair,
+// message, and signature instead of generating a fresh one per run. There is
+// no live substrate chain reachable from this test environment to pull a
+// real ecdsa-twin signature from, so this can't catch a wire-incompatible
+// chain on its own - but pinning the bytes as a literal fixture means a
+// future change to the signing/hashing convention (blake2b-256 pre-hash,
+// raw compact R||S with no DER wrapping or recovery header) shows up as a
+// diff against known-good bytes instead of silently passing because both
+// sides of a round trip moved together.
+func TestEcdsaVerifyingKey_Verify_KnownVector(t *testing.T) {
+	pub, err := hex.DecodeString("0284bf7562262bbd6940085748f3be6afa52ae317155181ece31b66351ccffa4b0")
+	if err != nil {
+		t.Fatalf("failed to decode fixture pubkey: %v", err)
+	}
+	sig, err := hex.DecodeString("2decab0ec4bcd9d037970e2508e88f64f85625caf1bb07aab8cfefb47f68fd834d424ca717613e5b5e1cf6161c5935a2aeb52e96686a13775480e0323484b49e")
+	if err != nil {
+		t.Fatalf("failed to decode fixture signature: %v", err)
+	}
+	msg := []byte("rmb substrate ecdsa test vector: twin 7 challenge")
+
+	key := EcdsaVerifyingKey(pub)
+	if !key.Verify(msg, sig) {
+		t.Fatal("expected pinned ECDSA vector to verify")
+	}
+
+	tampered := append([]byte(nil), sig...)
+	tampered[0] ^= 0xff
+	if key.Verify(msg, tampered) {
+		t.Fatal("expected tampered ECDSA signature to fail verification")
+	}
+
+	if key.Verify([]byte("a different challenge"), sig) {
+		t.Fatal("expected signature over a different message to fail verification")
+	}
+}
+
+// TestEcdsaVerifyingKey_Verify round-trips a freshly generated secp256k1 key
+// through the same compact R||S encoding substrate's ecdsa twin accounts
+// sign with, then checks that Verify accepts the valid signature and rejects
+// a tampered one. This only proves internal consistency of Sign/Verify, not
+// wire compatibility with a real substrate node - see
+// TestEcdsaVerifyingKey_Verify_KnownVector for a pinned fixture.
+func TestEcdsaVerifyingKey_Verify(t *testing.T) {
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	msg := []byte("rmb envelope challenge")
+	hash := blake2b.Sum256(msg)
+
+	compact, err := ecdsa.SignCompact(priv, hash[:], true)
+	if err != nil {
+		t.Fatalf("failed to sign challenge: %v", err)
+	}
+	// SignCompact prepends a recovery header byte that substrate's raw
+	// ecdsa signatures don't carry; Verify only expects r||s.
+	sig := compact[1:]
+
+	key := EcdsaVerifyingKey(priv.PubKey().SerializeCompressed())
+	if !key.Verify(msg, sig) {
+		t.Fatal("expected valid ECDSA signature to verify")
+	}
+
+	tampered := append([]byte(nil), sig...)
+	tampered[0] ^= 0xff
+	if key.Verify(msg, tampered) {
+		t.Fatal("expected tampered ECDSA signature to fail verification")
+	}
+}
@@ -0,0 +1,160 @@
+package direct
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/threefoldtech/substrate-client"
+)
+
+type twinCacheEntry struct {
+	id      uint32
+	twin    *substrate.Twin
+	err     error
+	expires time.Time
+}
+
+// TwinUpdatedEvent signals that a twin's on-chain entry (public key, relay,
+// RMB address, ...) changed and any cached resolution of it is now stale.
+type TwinUpdatedEvent struct {
+	TwinID uint32
+}
+
+// CachedTwinResolver is a TwinResolver backed by an LRU cache with a
+// configurable TTL, plus optional shorter-lived negative caching for "twin
+// not found" lookups.
+type CachedTwinResolver struct {
+	resolver TwinResolver
+
+	size   int
+	ttl    time.Duration
+	negTTL time.Duration
+
+	mu       sync.Mutex
+	elements map[uint32]*list.Element
+	order    *list.List // front = most recently used, back = least recently used
+	inflight map[uint32]*twinCall
+	gen      map[uint32]uint64 // bumped by Invalidate so a fetch started before it can't write stale data back in
+}
+
+// NewCachedTwinResolver wraps resolver with an LRU cache holding up to size
+// entries, each valid for ttl, and negTTL for negative caching; pass 0 to
+// either size or negTTL to disable that behaviour.
+func NewCachedTwinResolver(resolver TwinResolver, size int, ttl, negTTL time.Duration) *CachedTwinResolver {
+	return &CachedTwinResolver{
+		resolver: resolver,
+		size:     size,
+		ttl:      ttl,
+		negTTL:   negTTL,
+		elements: make(map[uint32]*list.Element),
+		order:    list.New(),
+		inflight: make(map[uint32]*twinCall),
+		gen:      make(map[uint32]uint64),
+	}
+}
+
+// GetTwin implements TwinResolver. Concurrent callers requesting the same
+// twin ID while it's not yet cached collapse onto a single in-flight RPC.
+func (r *CachedTwinResolver) GetTwin(id uint32) (*substrate.Twin, error) {
+	if r.size <= 0 {
+		return r.resolver.GetTwin(id)
+	}
+
+	r.mu.Lock()
+	if elem, ok := r.elements[id]; ok {
+		entry := elem.Value.(twinCacheEntry)
+		if time.Now().Before(entry.expires) {
+			r.order.MoveToFront(elem)
+			r.mu.Unlock()
+			return entry.twin, entry.err
+		}
+	}
+	if call, ok := r.inflight[id]; ok {
+		r.mu.Unlock()
+		call.wg.Wait()
+		return call.twin, call.err
+	}
+
+	startGen := r.gen[id]
+	call := &twinCall{}
+	call.wg.Add(1)
+	r.inflight[id] = call
+	r.mu.Unlock()
+
+	twin, err := r.resolver.GetTwin(id)
+	call.twin, call.err = twin, err
+
+	// Don't cache over a concurrent Invalidate: it means the result above may
+	// already be stale.
+	r.mu.Lock()
+	if r.gen[id] == startGen {
+		if err != nil {
+			// Only "twin not found" is negative-cacheable; other errors are transient.
+			if r.negTTL > 0 && errors.Is(err, substrate.ErrNotFound) {
+				r.set(twinCacheEntry{id: id, err: err, expires: time.Now().Add(r.negTTL)})
+			}
+		} else {
+			r.set(twinCacheEntry{id: id, twin: twin, expires: time.Now().Add(r.ttl)})
+		}
+	}
+	delete(r.inflight, id)
+	r.mu.Unlock()
+
+	call.wg.Done()
+
+	return twin, err
+}
+
+// Invalidate drops id from the cache so the next GetTwin call fetches it
+// fresh from substrate, and marks any fetch already in flight for id as
+// stale so it can't repopulate the cache once it returns. A GetTwin call made
+// while that stale fetch is still in flight joins it and receives its result.
+func (r *CachedTwinResolver) Invalidate(id uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if elem, ok := r.elements[id]; ok {
+		r.order.Remove(elem)
+		delete(r.elements, id)
+	}
+	r.gen[id]++
+}
+
+// WatchInvalidations invalidates cached twins as update events arrive on
+// events, until ctx is cancelled or events is closed.
+func (r *CachedTwinResolver) WatchInvalidations(ctx context.Context, events <-chan TwinUpdatedEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			r.Invalidate(ev.TwinID)
+		}
+	}
+}
+
+// set stores entry, evicting the least recently used entry first if the
+// cache is full. Callers must hold r.mu; only called when caching is enabled
+// (r.size > 0), since GetTwin bypasses the cache entirely otherwise.
+func (r *CachedTwinResolver) set(entry twinCacheEntry) {
+	if elem, ok := r.elements[entry.id]; ok {
+		elem.Value = entry
+		r.order.MoveToFront(elem)
+		return
+	}
+
+	if len(r.elements) >= r.size {
+		oldest := r.order.Back()
+		if oldest != nil {
+			r.order.Remove(oldest)
+			delete(r.elements, oldest.Value.(twinCacheEntry).id)
+		}
+	}
+
+	r.elements[entry.id] = r.order.PushFront(entry)
+}
@@ -0,0 +1,208 @@
+package direct
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// countingResolver is a TwinResolver that counts GetTwin calls per twin ID,
+// so tests can assert how many RPCs a cache actually issued. err, if set, is
+// returned for every twin ID instead of looking twins up.
+type countingResolver struct {
+	mu    sync.Mutex
+	calls map[uint32]int
+	twins map[uint32]*substrate.Twin
+	err   error
+}
+
+func newCountingResolver(twins map[uint32]*substrate.Twin) *countingResolver {
+	return &countingResolver{calls: make(map[uint32]int), twins: twins}
+}
+
+func (r *countingResolver) GetTwin(id uint32) (*substrate.Twin, error) {
+	r.mu.Lock()
+	r.calls[id]++
+	r.mu.Unlock()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	twin, ok := r.twins[id]
+	if !ok {
+		return nil, errors.Errorf("twin %d not found", id)
+	}
+	return twin, nil
+}
+
+func (r *countingResolver) callCount(id uint32) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[id]
+}
+
+// gatedResolver blocks its first GetTwin call until release is closed, so
+// tests can deterministically land a concurrent operation in the middle of
+// an in-flight fetch instead of racing on a sleep.
+type gatedResolver struct {
+	mu      sync.Mutex
+	calls   int
+	started chan struct{}
+	release chan struct{}
+	twin    *substrate.Twin
+	err     error
+}
+
+func newGatedResolver(twin *substrate.Twin, err error) *gatedResolver {
+	return &gatedResolver{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		twin:    twin,
+		err:     err,
+	}
+}
+
+func (r *gatedResolver) GetTwin(uint32) (*substrate.Twin, error) {
+	r.mu.Lock()
+	r.calls++
+	first := r.calls == 1
+	r.mu.Unlock()
+
+	if first {
+		close(r.started)
+	}
+	<-r.release
+
+	return r.twin, r.err
+}
+
+func (r *gatedResolver) callCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+func TestCachedTwinResolver_CachesAndDedupesConcurrentFetches(t *testing.T) {
+	const twinID = 7
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: {}})
+	r := NewCachedTwinResolver(resolver, 10, time.Minute, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.GetTwin(twinID); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := r.GetTwin(twinID); err != nil {
+		t.Fatalf("unexpected error on cached fetch: %v", err)
+	}
+
+	if calls := resolver.callCount(twinID); calls != 1 {
+		t.Fatalf("expected a single underlying fetch for twin %d, got %d", twinID, calls)
+	}
+}
+
+func TestCachedTwinResolver_SizeZeroDisablesCaching(t *testing.T) {
+	const twinID = 7
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: {}})
+	r := NewCachedTwinResolver(resolver, 0, time.Minute, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := r.GetTwin(twinID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if calls := resolver.callCount(twinID); calls != 3 {
+		t.Fatalf("expected size <= 0 to disable caching entirely, got %d calls for 3 fetches", calls)
+	}
+}
+
+func TestCachedTwinResolver_EvictsLeastRecentlyUsed(t *testing.T) {
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{1: {}, 2: {}, 3: {}})
+	r := NewCachedTwinResolver(resolver, 2, time.Minute, 0)
+
+	mustGet := func(id uint32) {
+		t.Helper()
+		if _, err := r.GetTwin(id); err != nil {
+			t.Fatalf("unexpected error fetching twin %d: %v", id, err)
+		}
+	}
+
+	mustGet(1)
+	mustGet(2)
+	mustGet(1) // touch 1 so 2 becomes the least recently used of the two
+	mustGet(3) // cache is full at size 2, so this evicts 2
+	mustGet(1) // still cached, since 3 evicted 2 rather than 1
+
+	mustGet(2)
+	if calls := resolver.callCount(2); calls != 2 {
+		t.Fatalf("expected twin 2 to have been evicted and refetched, got %d calls", calls)
+	}
+	if calls := resolver.callCount(1); calls != 1 {
+		t.Fatalf("expected twin 1 to stay cached after being touched, got %d calls", calls)
+	}
+}
+
+func TestCachedTwinResolver_NegativeCachesNotFoundOnly(t *testing.T) {
+	const twinID = 9
+
+	notFound := newCountingResolver(nil)
+	notFound.err = substrate.ErrNotFound
+	r := NewCachedTwinResolver(notFound, 10, time.Minute, time.Minute)
+
+	if _, err := r.GetTwin(twinID); !errors.Is(err, substrate.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := r.GetTwin(twinID); !errors.Is(err, substrate.ErrNotFound) {
+		t.Fatalf("expected cached ErrNotFound, got %v", err)
+	}
+	if calls := notFound.callCount(twinID); calls != 1 {
+		t.Fatalf("expected 'twin not found' to be negative-cached, got %d calls", calls)
+	}
+
+	transient := newCountingResolver(nil)
+	transient.err = errors.New("connection reset")
+	r2 := NewCachedTwinResolver(transient, 10, time.Minute, time.Minute)
+
+	r2.GetTwin(twinID)
+	r2.GetTwin(twinID)
+	if calls := transient.callCount(twinID); calls != 2 {
+		t.Fatalf("expected transient errors not to be negative-cached, got %d calls", calls)
+	}
+}
+
+func TestCachedTwinResolver_InvalidateDuringFetchDropsStaleResult(t *testing.T) {
+	const twinID = 3
+	gated := newGatedResolver(&substrate.Twin{}, nil)
+	r := NewCachedTwinResolver(gated, 10, time.Minute, 0)
+
+	fetchDone := make(chan struct{})
+	go func() {
+		r.GetTwin(twinID)
+		close(fetchDone)
+	}()
+
+	// Let the fetch above start, then rotate the twin's key while it's still
+	// in flight: the fetch can only return pre-rotation data at this point.
+	<-gated.started
+	r.Invalidate(twinID)
+	close(gated.release)
+	<-fetchDone
+
+	if _, err := r.GetTwin(twinID); err != nil {
+		t.Fatalf("unexpected error refetching twin %d: %v", twinID, err)
+	}
+	if calls := gated.callCount(); calls != 2 {
+		t.Fatalf("expected the post-invalidate fetch result to be dropped instead of cached, got %d calls", calls)
+	}
+}
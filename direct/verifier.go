@@ -0,0 +1,336 @@
+package direct
+
+import (
+	"context"
+	"encoding/hex"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/rmb-sdk-go/direct/types"
+	"github.com/threefoldtech/substrate-client"
+)
+
+// TwinResolver abstracts twin lookups so they can be mocked in tests and
+// cached across a batch of envelopes.
+type TwinResolver interface {
+	GetTwin(id uint32) (*substrate.Twin, error)
+}
+
+// substrateTwinResolver is the default TwinResolver backed directly by a
+// substrate client.
+type substrateTwinResolver struct {
+	sub *substrate.Substrate
+}
+
+func (r substrateTwinResolver) GetTwin(id uint32) (*substrate.Twin, error) {
+	return r.sub.GetTwin(id)
+}
+
+// verifyRequest is the unit of work queued by Add and picked up by a worker.
+// sig is the full decoded signature including its leading scheme byte; the
+// scheme itself is only known once the worker has fetched req.twin's account,
+// so it can't be resolved up front in Add.
+type verifyRequest struct {
+	twin      uint32
+	challenge []byte
+	sig       []byte
+	result    chan<- error
+}
+
+// SignatureVerifier verifies envelope signatures in the background across a
+// pool of workers instead of blocking the caller on one verification at a
+// time. sr25519 verification is notably slower than ed25519, so batching many
+// inbound envelopes and verifying them in parallel keeps a busy receive loop
+// from stalling on a single slow signature.
+//
+// Queue up envelopes with Add, then call Finish once the batch is complete:
+// Finish blocks until every queued envelope has been handled and returns the
+// first error seen, if any. Once one signature fails, envelopes still in the
+// queue are not verified; each is answered with that first error.
+type SignatureVerifier struct {
+	resolver TwinResolver
+	workers  int
+	registry *SchemeRegistry
+
+	queue     chan verifyRequest
+	stopWatch chan struct{}
+	wg        sync.WaitGroup
+	closeMu   sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	invalid bool
+	err     error
+
+	twinMu       sync.Mutex
+	twinCache    map[uint32]*substrate.Twin
+	twinInflight map[uint32]*twinCall
+}
+
+// twinCall tracks a single in-flight GetTwin RPC so concurrent workers
+// fetching the same twin ID collapse onto one request.
+type twinCall struct {
+	wg   sync.WaitGroup
+	twin *substrate.Twin
+	err  error
+}
+
+// NewSignatureVerifier creates a SignatureVerifier backed by sub. Use
+// WithWorkers to override the default worker count (GOMAXPROCS).
+func NewSignatureVerifier(sub *substrate.Substrate) *SignatureVerifier {
+	return &SignatureVerifier{
+		resolver:     substrateTwinResolver{sub: sub},
+		workers:      runtime.GOMAXPROCS(0),
+		registry:     DefaultRegistry,
+		queue:        make(chan verifyRequest, 128),
+		stopWatch:    make(chan struct{}),
+		twinCache:    make(map[uint32]*substrate.Twin),
+		twinInflight: make(map[uint32]*twinCall),
+	}
+}
+
+// WithWorkers overrides the number of background verification workers. Must
+// be called before Start.
+func (v *SignatureVerifier) WithWorkers(workers int) *SignatureVerifier {
+	if workers > 0 {
+		v.workers = workers
+	}
+	return v
+}
+
+// WithRegistry overrides the SchemeRegistry used to look up verifiers,
+// letting callers verify against schemes that aren't in DefaultRegistry.
+// Must be called before Start.
+func (v *SignatureVerifier) WithRegistry(registry *SchemeRegistry) *SignatureVerifier {
+	if registry != nil {
+		v.registry = registry
+	}
+	return v
+}
+
+// WithResolver overrides the TwinResolver used to look up twins, e.g. with a
+// CachedTwinResolver shared across many SignatureVerifier batches. Must be
+// called before Start.
+func (v *SignatureVerifier) WithResolver(resolver TwinResolver) *SignatureVerifier {
+	if resolver != nil {
+		v.resolver = resolver
+	}
+	return v
+}
+
+// Start spins up the background workers. It must be called once before Add.
+func (v *SignatureVerifier) Start(ctx context.Context) {
+	for i := 0; i < v.workers; i++ {
+		v.wg.Add(1)
+		go v.worker()
+	}
+	go v.watchCancel(ctx)
+}
+
+// watchCancel closes the queue as soon as ctx is done, so every request still
+// buffered in it is replied to with the batch's first error (ctx.Err(), unless
+// a signature had already failed) instead of hanging forever. It
+// shares the same close path as Finish so a concurrent Add can never enqueue
+// a request after the queue is closed: closeMu serializes the two, meaning
+// every send that wins the race completes before close(v.queue) runs, and is
+// therefore guaranteed to be drained by a worker's range loop. It exits
+// without touching anything if Finish closes the queue first, so a
+// SignatureVerifier started with a long-lived ctx doesn't leak this goroutine
+// once its batch finishes normally.
+func (v *SignatureVerifier) watchCancel(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		v.close(ctx.Err())
+	case <-v.stopWatch:
+	}
+}
+
+// close closes v.queue at most once. reason, if non-nil, marks the batch
+// invalid with reason so Finish reports it and workers stop verifying
+// whatever is left in the queue, replying the batch's first error to it
+// instead. If the batch was already invalid, that first error is the earlier
+// one and reason is dropped.
+func (v *SignatureVerifier) close(reason error) {
+	v.closeOnce.Do(func() {
+		if reason != nil {
+			v.setInvalid(reason)
+		}
+		v.closeMu.Lock()
+		v.closed = true
+		v.closeMu.Unlock()
+		close(v.stopWatch)
+		close(v.queue)
+	})
+}
+
+// Add queues env for verification and returns a channel that receives the
+// verification result once a worker picks it up. The channel is closed right
+// after the result is sent.
+func (v *SignatureVerifier) Add(env *types.Envelope) <-chan error {
+	result := make(chan error, 1)
+
+	sig := env.GetSignature()
+	if sig == nil {
+		result <- errors.New("could not get signature from envelope")
+		close(result)
+		return result
+	}
+
+	decoded, err := hex.DecodeString(string(sig))
+	if err != nil {
+		result <- errors.Wrap(err, "could not decode signature")
+		close(result)
+		return result
+	}
+	if len(decoded) == 0 {
+		result <- errors.New("signature is empty")
+		close(result)
+		return result
+	}
+
+	data, err := Challenge(env)
+	if err != nil {
+		result <- errors.Wrap(err, "could not get challenge hash")
+		close(result)
+		return result
+	}
+
+	// held for the queue send so Finish can't close v.queue out from under a
+	// concurrent Add.
+	v.closeMu.RLock()
+	defer v.closeMu.RUnlock()
+	if v.closed {
+		result <- errors.New("signature verifier is already finished")
+		close(result)
+		return result
+	}
+
+	v.queue <- verifyRequest{
+		twin:      env.Source.Twin,
+		challenge: data,
+		sig:       decoded,
+		result:    result,
+	}
+
+	return result
+}
+
+// Finish waits for all queued envelopes to be handled and returns the first
+// invalid result seen, or nil once the whole batch has drained cleanly. It is
+// safe to call more than once.
+func (v *SignatureVerifier) Finish() error {
+	v.close(nil)
+	v.wg.Wait()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.err
+}
+
+func (v *SignatureVerifier) worker() {
+	defer v.wg.Done()
+
+	for req := range v.queue {
+		if v.isInvalid() {
+			// a sibling worker already found a bad signature, no point
+			// spending cycles verifying the rest of the batch.
+			req.result <- v.firstErr()
+			close(req.result)
+			continue
+		}
+
+		err := v.verify(req)
+		if err != nil {
+			v.setInvalid(err)
+		}
+		req.result <- err
+		close(req.result)
+	}
+}
+
+func (v *SignatureVerifier) verify(req verifyRequest) error {
+	wireScheme, err := v.registry.WireScheme(req.sig)
+	if err != nil {
+		return err
+	}
+
+	twin, err := v.getTwin(req.twin)
+	if err != nil {
+		return errors.Wrapf(err, "could not get twin from twin id, twinID: %d", req.twin)
+	}
+
+	signatureType, pk, err := accountSignatureType(twin, wireScheme)
+	if err != nil {
+		return errors.Wrapf(err, "could not determine signature scheme for twin %d", req.twin)
+	}
+
+	verifier, err := v.registry.Verifier(signatureType, pk)
+	if err != nil {
+		return err
+	}
+
+	if !verifier.Verify(req.challenge, req.sig[1:]) {
+		return errors.New("could not verify signature")
+	}
+
+	return nil
+}
+
+// getTwin fetches a twin through the resolver, caching the result for the
+// lifetime of this SignatureVerifier so a batch never fetches the same twin
+// twice. Concurrent workers requesting the same twin ID collapse onto a
+// single in-flight RPC instead of each firing their own.
+func (v *SignatureVerifier) getTwin(id uint32) (*substrate.Twin, error) {
+	v.twinMu.Lock()
+	if twin, ok := v.twinCache[id]; ok {
+		v.twinMu.Unlock()
+		return twin, nil
+	}
+	if call, ok := v.twinInflight[id]; ok {
+		v.twinMu.Unlock()
+		call.wg.Wait()
+		return call.twin, call.err
+	}
+
+	call := &twinCall{}
+	call.wg.Add(1)
+	v.twinInflight[id] = call
+	v.twinMu.Unlock()
+
+	twin, err := v.resolver.GetTwin(id)
+	call.twin, call.err = twin, err
+
+	v.twinMu.Lock()
+	if err == nil {
+		v.twinCache[id] = twin
+	}
+	delete(v.twinInflight, id)
+	v.twinMu.Unlock()
+
+	call.wg.Done()
+
+	return twin, err
+}
+
+func (v *SignatureVerifier) isInvalid() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.invalid
+}
+
+func (v *SignatureVerifier) setInvalid(err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.invalid {
+		v.invalid = true
+		v.err = err
+	}
+}
+
+func (v *SignatureVerifier) firstErr() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.err
+}
@@ -0,0 +1,326 @@
+package direct
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	scaletypes "github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/threefoldtech/rmb-sdk-go/direct/types"
+	"github.com/threefoldtech/substrate-client"
+	"golang.org/x/crypto/blake2b"
+)
+
+// envelopeWithSig builds an envelope from twinID whose Signature field is the
+// hex encoding of prefix followed by sig, mirroring the wire format a peer
+// sends: a one-byte scheme tag followed by the raw signature bytes.
+func envelopeWithSig(twinID uint32, prefix byte, sig []byte) *types.Envelope {
+	env := &types.Envelope{Source: &types.Address{Twin: twinID}}
+	wire := append([]byte{prefix}, sig...)
+	env.Signature = []byte(hex.EncodeToString(wire))
+	return env
+}
+
+// signedEnvelope builds an envelope from twinID, signed with priv the same
+// way a peer would: scheme byte 'e' followed by the ed25519 signature over
+// the envelope's challenge, hex-encoded into the Signature field.
+func signedEnvelope(twinID uint32, priv ed25519.PrivateKey) (*types.Envelope, error) {
+	env := envelopeWithSig(twinID, 0, nil)
+	challenge, err := Challenge(env)
+	if err != nil {
+		return nil, err
+	}
+	return envelopeWithSig(twinID, 'e', ed25519.Sign(priv, challenge)), nil
+}
+
+// ecdsaSignedEnvelope builds an envelope from twinID, signed with priv over
+// the envelope's challenge the way a substrate ecdsa account signs: the
+// blake2b-256 hash of the challenge, as a compact R||S signature with the
+// recovery header byte stripped.
+func ecdsaSignedEnvelope(twinID uint32, priv *btcec.PrivateKey) (*types.Envelope, error) {
+	env := envelopeWithSig(twinID, 0, nil)
+	challenge, err := Challenge(env)
+	if err != nil {
+		return nil, err
+	}
+	hash := blake2b.Sum256(challenge)
+	compact, err := ecdsa.SignCompact(priv, hash[:], true)
+	if err != nil {
+		return nil, err
+	}
+	return envelopeWithSig(twinID, 'c', compact[1:]), nil
+}
+
+func ed25519Twin(pub ed25519.PublicKey) *substrate.Twin {
+	var account substrate.AccountID
+	copy(account[:], pub)
+	return &substrate.Twin{Account: account}
+}
+
+// ecdsaTwin builds a Twin the way CreateTwin/UpdateTwin do for an ecdsa
+// account: the account id is unrelated to the real key (substrate hashes the
+// compressed pubkey to derive it), and the real compressed pubkey lives in
+// Pk, which is what verification actually keys off.
+func ecdsaTwin(pub []byte) *substrate.Twin {
+	var account substrate.AccountID
+	hash := blake2b.Sum256(pub)
+	copy(account[:], hash[:])
+	return &substrate.Twin{
+		Account: account,
+		Pk:      scaletypes.NewOptionBytes(scaletypes.Bytes(pub)),
+	}
+}
+
+func TestSignatureVerifier_GetTwinDedupesConcurrentFetches(t *testing.T) {
+	const twinID = 42
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: ed25519Twin(pub)})
+	v := NewSignatureVerifier(nil).WithResolver(resolver)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.getTwin(twinID); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls := resolver.callCount(twinID); calls != 1 {
+		t.Fatalf("expected a single GetTwin RPC for twin %d across the batch, got %d", twinID, calls)
+	}
+}
+
+func TestSignatureVerifier_AddDuringFinishDoesNotPanic(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	const envelopes = 20
+	envs := make([]*types.Envelope, envelopes)
+	for i := range envs {
+		env, err := signedEnvelope(uint32(i), priv)
+		if err != nil {
+			t.Fatalf("failed to build envelope %d: %v", i, err)
+		}
+		envs[i] = env
+	}
+
+	resolver := newCountingResolver(nil)
+	v := NewSignatureVerifier(nil).WithResolver(resolver).WithWorkers(4)
+	v.Start(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(len(envs))
+	for _, env := range envs {
+		go func(env *types.Envelope) {
+			defer wg.Done()
+			<-v.Add(env)
+		}(env)
+	}
+
+	// Racing Finish against the Adds above is the point of the test: if Add
+	// and Finish ever raced on closing v.queue, this would panic with "send
+	// on closed channel" instead of returning cleanly.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		v.Finish()
+	}()
+
+	wg.Wait()
+}
+
+func TestSignatureVerifier_ContextCancellationDrainsQueue(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	env, err := signedEnvelope(1, priv)
+	if err != nil {
+		t.Fatalf("failed to build envelope: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	v := NewSignatureVerifier(nil).WithResolver(newCountingResolver(nil)).WithWorkers(1)
+	v.Start(ctx)
+
+	select {
+	case err := <-v.Add(env):
+		if err == nil {
+			t.Fatal("expected an error once the verifier's context is cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Add's result channel never resolved after context cancellation")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- v.Finish() }()
+
+	select {
+	case err := <-done:
+		// Whether the worker observes the cancellation before or after
+		// picking up the queued request is a race: Finish may report
+		// ctx.Err() or whatever error the worker hit trying to verify it.
+		// Either way the batch must come back invalid, and Finish must
+		// return promptly instead of hanging on a leaked worker.
+		if err == nil {
+			t.Fatal("expected Finish to report an error once the verifier's context is cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Finish never returned after context cancellation; a worker goroutine may have leaked")
+	}
+}
+
+// TestSignatureVerifier_VerifiesRealEd25519Signature runs a validly signed
+// ed25519 envelope all the way through Add/Finish against a real twin, so a
+// regression that breaks actual verification (wrong challenge bytes, wrong
+// key routing, ...) fails a test instead of only a resolver mock that never
+// reaches accountSignatureType/Verify.
+func TestSignatureVerifier_VerifiesRealEd25519Signature(t *testing.T) {
+	const twinID = 7
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	env, err := signedEnvelope(twinID, priv)
+	if err != nil {
+		t.Fatalf("failed to build envelope: %v", err)
+	}
+
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: ed25519Twin(pub)})
+	v := NewSignatureVerifier(nil).WithResolver(resolver)
+	v.Start(context.Background())
+
+	if err := <-v.Add(env); err != nil {
+		t.Fatalf("expected valid ed25519 envelope to verify, got: %v", err)
+	}
+	if err := v.Finish(); err != nil {
+		t.Fatalf("expected Finish to report no error, got: %v", err)
+	}
+}
+
+// TestSignatureVerifier_VerifiesRealEcdsaSignature is the ecdsa counterpart
+// of TestSignatureVerifier_VerifiesRealEd25519Signature: a real secp256k1
+// keypair, signed the way a substrate ecdsa twin account signs, verified
+// against a twin whose Pk carries that same compressed pubkey.
+func TestSignatureVerifier_VerifiesRealEcdsaSignature(t *testing.T) {
+	const twinID = 7
+	priv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	env, err := ecdsaSignedEnvelope(twinID, priv)
+	if err != nil {
+		t.Fatalf("failed to build envelope: %v", err)
+	}
+
+	pub := priv.PubKey().SerializeCompressed()
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: ecdsaTwin(pub)})
+	v := NewSignatureVerifier(nil).WithResolver(resolver)
+	v.Start(context.Background())
+
+	if err := <-v.Add(env); err != nil {
+		t.Fatalf("expected valid ecdsa envelope to verify, got: %v", err)
+	}
+	if err := v.Finish(); err != nil {
+		t.Fatalf("expected Finish to report no error, got: %v", err)
+	}
+}
+
+// TestSignatureVerifier_RejectsForgedSchemeAgainstEcdsaTwin is a regression
+// test against picking the verifier from the envelope's self-reported scheme
+// byte instead of the twin's actual account variant. Here the twin is an
+// ecdsa account (Pk set), but the envelope claims
+// ed25519 and carries ed25519-shaped signature bytes signed by a completely
+// unrelated key. If the scheme byte were ever trusted again, this forged
+// envelope would be checked as ed25519 against account bytes that are just a
+// hash of the real key, not the key itself - accountSignatureType must
+// instead force ecdsa verification against twin.Pk regardless of what the
+// envelope claims, and reject it.
+func TestSignatureVerifier_RejectsForgedSchemeAgainstEcdsaTwin(t *testing.T) {
+	const twinID = 7
+	ecdsaPriv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	_, forgerPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	forged, err := signedEnvelope(twinID, forgerPriv)
+	if err != nil {
+		t.Fatalf("failed to build forged envelope: %v", err)
+	}
+
+	pub := ecdsaPriv.PubKey().SerializeCompressed()
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: ecdsaTwin(pub)})
+	v := NewSignatureVerifier(nil).WithResolver(resolver)
+	v.Start(context.Background())
+
+	if err := <-v.Add(forged); err == nil {
+		t.Fatal("expected an ed25519-scheme envelope forged against an ecdsa twin to be rejected")
+	}
+	v.Finish()
+}
+
+// TestVerifySignature_RealEd25519Signature exercises the package-level
+// VerifySignature entry point end to end, not just SignatureVerifier.verify.
+func TestVerifySignature_RealEd25519Signature(t *testing.T) {
+	const twinID = 7
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	env, err := signedEnvelope(twinID, priv)
+	if err != nil {
+		t.Fatalf("failed to build envelope: %v", err)
+	}
+
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: ed25519Twin(pub)})
+	if err := VerifySignature(nil, env, nil, resolver); err != nil {
+		t.Fatalf("expected valid ed25519 envelope to verify, got: %v", err)
+	}
+}
+
+// TestVerifySignature_RejectsForgedSchemeAgainstEcdsaTwin is
+// TestSignatureVerifier_RejectsForgedSchemeAgainstEcdsaTwin's counterpart for
+// the package-level VerifySignature entry point.
+func TestVerifySignature_RejectsForgedSchemeAgainstEcdsaTwin(t *testing.T) {
+	const twinID = 7
+	ecdsaPriv, err := btcec.NewPrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate ecdsa key: %v", err)
+	}
+	_, forgerPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate ed25519 key: %v", err)
+	}
+
+	forged, err := signedEnvelope(twinID, forgerPriv)
+	if err != nil {
+		t.Fatalf("failed to build forged envelope: %v", err)
+	}
+
+	pub := ecdsaPriv.PubKey().SerializeCompressed()
+	resolver := newCountingResolver(map[uint32]*substrate.Twin{twinID: ecdsaTwin(pub)})
+	if err := VerifySignature(nil, forged, nil, resolver); err == nil {
+		t.Fatal("expected an ed25519-scheme envelope forged against an ecdsa twin to be rejected")
+	}
+}